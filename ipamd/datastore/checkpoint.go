@@ -0,0 +1,170 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+const (
+	// DefaultRetainedIPCheckpointPath is where retained IP bindings are
+	// persisted so they survive an ipamd restart.
+	DefaultRetainedIPCheckpointPath = "/var/run/aws-node/retained-ips.json"
+
+	// retainedIPTTL bounds how long a retained binding is kept around
+	// waiting for its pod to come back before its IP is reclaimed.
+	retainedIPTTL = 1 * time.Hour
+)
+
+// retainedBinding is a (namespace, name) -> IP binding kept alive across pod
+// restarts for pods that opted in via the retain-ip annotation, e.g. KubeVirt
+// VirtualMachineInstances.
+type retainedBinding struct {
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	IP           string    `json:"ip"`
+	DeviceNumber int       `json:"deviceNumber"`
+	ReleasedAt   time.Time `json:"releasedAt"`
+
+	// Subnet is the name of the subnet pool IP came from, or "" for the
+	// instance's default warm pool. It's needed so the IP is returned to the
+	// right pool if its pod never comes back and GCRetainedIPs reclaims it.
+	Subnet string `json:"subnet,omitempty"`
+}
+
+// RetainPodIPv4Address moves pod's active binding into the retained set
+// instead of returning its IP to the warm pool, and persists the retained
+// set to disk so it survives an ipamd restart.
+func (ds *DataStore) RetainPodIPv4Address(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ds.mu.Lock()
+	assignment, ok := ds.podIPs[key(pod)]
+	if !ok {
+		ds.mu.Unlock()
+		return "", 0, ErrUnknownPod
+	}
+	if pod.Container != "" && assignment.container != "" && assignment.container != pod.Container {
+		ds.mu.Unlock()
+		return "", 0, ErrUnknownPod
+	}
+
+	delete(ds.podIPs, key(pod))
+	ds.retainedIPs[key(pod)] = retainedBinding{
+		Namespace:    pod.Namespace,
+		Name:         pod.Name,
+		IP:           assignment.ip,
+		DeviceNumber: assignment.deviceNumber,
+		ReleasedAt:   time.Now(),
+		Subnet:       assignment.subnet,
+	}
+	ds.mu.Unlock()
+
+	if err := ds.saveRetainedIPCheckpoint(); err != nil {
+		log.Errorf("Failed to checkpoint retained IP for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	return assignment.ip, assignment.deviceNumber, nil
+}
+
+// AssignPodIPv4AddressRetained looks for a retained binding for pod's
+// namespace/name and reuses its IP if one exists; otherwise it falls back to
+// AssignPodIPv4Address to hand out a fresh IP from the warm pool.
+func (ds *DataStore) AssignPodIPv4AddressRetained(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ds.mu.Lock()
+	binding, ok := ds.retainedIPs[key(pod)]
+	if ok {
+		delete(ds.retainedIPs, key(pod))
+		ds.podIPs[key(pod)] = ipAssignment{ip: binding.IP, deviceNumber: binding.DeviceNumber, container: pod.Container, subnet: binding.Subnet}
+	}
+	ds.mu.Unlock()
+
+	if ok {
+		if err := ds.saveRetainedIPCheckpoint(); err != nil {
+			log.Errorf("Failed to update retained IP checkpoint for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		return binding.IP, binding.DeviceNumber, nil
+	}
+
+	return ds.AssignPodIPv4Address(pod)
+}
+
+// GCRetainedIPs reclaims retained bindings whose pod hasn't come back within
+// retainedIPTTL, returning their IPs to the warm pool. It should be run
+// periodically from a background goroutine.
+func (ds *DataStore) GCRetainedIPs(now time.Time) int {
+	ds.mu.Lock()
+	var reclaimed int
+	for k, binding := range ds.retainedIPs {
+		if now.Sub(binding.ReleasedAt) < retainedIPTTL {
+			continue
+		}
+		delete(ds.retainedIPs, k)
+		ds.freeDeviceNumber(binding.DeviceNumber)
+		ds.releaseToPool(ipAssignment{ip: binding.IP, subnet: binding.Subnet})
+		reclaimed++
+		log.Infof("Reclaimed stale retained IP %s for pod %s/%s after %s", binding.IP, binding.Namespace, binding.Name, retainedIPTTL)
+	}
+	ds.mu.Unlock()
+
+	if reclaimed > 0 {
+		if err := ds.saveRetainedIPCheckpoint(); err != nil {
+			log.Errorf("Failed to update retained IP checkpoint after GC: %v", err)
+		}
+	}
+	return reclaimed
+}
+
+// LoadRetainedIPCheckpoint restores the retained-IP set from disk, e.g. after
+// ipamd restarts. A missing checkpoint file is not an error.
+func (ds *DataStore) LoadRetainedIPCheckpoint() error {
+	raw, err := ioutil.ReadFile(ds.checkpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bindings []retainedBinding
+	if err := json.Unmarshal(raw, &bindings); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, b := range bindings {
+		ds.retainedIPs[podKey{namespace: b.Namespace, name: b.Name}] = b
+	}
+	return nil
+}
+
+func (ds *DataStore) saveRetainedIPCheckpoint() error {
+	ds.mu.Lock()
+	bindings := make([]retainedBinding, 0, len(ds.retainedIPs))
+	for _, b := range ds.retainedIPs {
+		bindings = append(bindings, b)
+	}
+	ds.mu.Unlock()
+
+	raw, err := json.Marshal(bindings)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ds.checkpointPath, raw, 0600)
+}
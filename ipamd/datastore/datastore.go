@@ -0,0 +1,311 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package datastore tracks the ENIs and IP addresses assigned to this
+// instance and which pod, if any, each one is currently bound to.
+package datastore
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+// ErrUnknownPod is returned when a pod's binding can't be found, e.g.
+// because ipamd was restarted and the binding wasn't recovered yet.
+var ErrUnknownPod = errors.New("datastore: unknown pod")
+
+// ErrNoAvailableIPAddress is returned when the warm pool has no spare
+// secondary IP address to assign to a pod.
+var ErrNoAvailableIPAddress = errors.New("datastore: no available IP addresses in the warm pool")
+
+// podKey identifies a pod binding by namespace/name alone. The sandbox
+// container ID, when known, is kept on the ipAssignment instead and checked
+// separately so a binding from a leaked sandbox can be told apart.
+type podKey struct {
+	namespace string
+	name      string
+}
+
+type ipAssignment struct {
+	ip           string
+	deviceNumber int
+	container    string
+
+	// subnet is the name of the subnet pool this IP came from, or "" for the
+	// instance's default warm pool. It's needed so the IP is returned to the
+	// right pool on release.
+	subnet string
+}
+
+// DataStore is ipamd's in-memory record of ENI/IP allocation. It is safe for
+// concurrent use.
+type DataStore struct {
+	mu sync.Mutex
+
+	// warmIPs are secondary IPs attached to the instance's ENIs that are not
+	// currently bound to a pod.
+	warmIPs []string
+
+	// subnetWarmIPs are secondary IPs attached to per-subnet ENIs, keyed by
+	// subnet name (see AssignPodIPv4AddressFromSubnet), not currently bound
+	// to a pod. These are kept separate from warmIPs because they live on a
+	// different subnet/route table than the instance's primary ENI.
+	subnetWarmIPs map[string][]string
+
+	// podIPs holds the secondary-IP-pool bindings, keyed by namespace/name.
+	podIPs map[podKey]ipAssignment
+
+	// branchENIs holds the branch-ENI-pool bindings, keyed by namespace/name.
+	// This is a separate pool from podIPs: branch ENIs are never returned to
+	// warmIPs, they're deleted outright on release.
+	branchENIs map[podKey]string // -> branch ENI ID
+
+	// pendingRelease holds bindings that MarkPodIPForRelease has taken out of
+	// podIPs but that FreeReleasedPodIP hasn't yet returned to warmIPs. An IP
+	// sits here, unavailable for reuse, while the CNI plugin confirms the
+	// previous pod's route rules and iptables SNAT exclusions are torn down.
+	pendingRelease map[podKey]ipAssignment
+
+	// retainedIPs holds sticky bindings for pods that asked to keep their IP
+	// across a restart (see RetainPodIPv4Address), checkpointed to disk at
+	// checkpointPath so they survive an ipamd restart too.
+	retainedIPs    map[podKey]retainedBinding
+	checkpointPath string
+
+	// nextDeviceNumber and freeDeviceNumbers back a monotonic/free-list
+	// allocator for pod device numbers: freeDeviceNumbers is tried first so
+	// that numbers released by one pod are reused before handing out a new
+	// one, and nextDeviceNumber is only advanced when the free list is empty.
+	// A device number is never reused while its pod is still alive, even if
+	// another pod's binding is released in between.
+	nextDeviceNumber  int
+	freeDeviceNumbers []int
+}
+
+// NewDataStore creates an empty DataStore that checkpoints retained IP
+// bindings to DefaultRetainedIPCheckpointPath.
+func NewDataStore() *DataStore {
+	return &DataStore{
+		subnetWarmIPs:  make(map[string][]string),
+		podIPs:         make(map[podKey]ipAssignment),
+		branchENIs:     make(map[podKey]string),
+		pendingRelease: make(map[podKey]ipAssignment),
+		retainedIPs:    make(map[podKey]retainedBinding),
+		checkpointPath: DefaultRetainedIPCheckpointPath,
+	}
+}
+
+// AddWarmIP adds a secondary IP to the warm pool, e.g. after ipamd attaches a
+// new ENI or allocates additional secondary IPs on an existing one.
+func (ds *DataStore) AddWarmIP(ip string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.warmIPs = append(ds.warmIPs, ip)
+}
+
+func key(pod *k8sapi.K8SPodInfo) podKey {
+	return podKey{namespace: pod.Namespace, name: pod.Name}
+}
+
+// allocDeviceNumber returns a device number not currently held by any live
+// pod binding, preferring one freed by a previous release over handing out a
+// new one. Callers must hold ds.mu.
+func (ds *DataStore) allocDeviceNumber() int {
+	if n := len(ds.freeDeviceNumbers); n > 0 {
+		d := ds.freeDeviceNumbers[n-1]
+		ds.freeDeviceNumbers = ds.freeDeviceNumbers[:n-1]
+		return d
+	}
+	d := ds.nextDeviceNumber
+	ds.nextDeviceNumber++
+	return d
+}
+
+// freeDeviceNumber returns d to the free list for reuse by a future
+// allocDeviceNumber call. Callers must hold ds.mu.
+func (ds *DataStore) freeDeviceNumber(d int) {
+	ds.freeDeviceNumbers = append(ds.freeDeviceNumbers, d)
+}
+
+// AssignPodIPv4Address assigns a secondary IP from the warm pool to pod.
+func (ds *DataStore) AssignPodIPv4Address(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if len(ds.warmIPs) == 0 {
+		return "", 0, ErrNoAvailableIPAddress
+	}
+
+	ip := ds.warmIPs[len(ds.warmIPs)-1]
+	ds.warmIPs = ds.warmIPs[:len(ds.warmIPs)-1]
+	deviceNumber := ds.allocDeviceNumber()
+
+	ds.podIPs[key(pod)] = ipAssignment{ip: ip, deviceNumber: deviceNumber, container: pod.Container}
+	return ip, deviceNumber, nil
+}
+
+// AddSubnetWarmIP adds an IP from a per-subnet ENI to the named
+// subnet's own pool, e.g. after ipamd allocates an ENI to satisfy the first
+// AssignPodIPv4AddressFromSubnet call for that subnet.
+func (ds *DataStore) AddSubnetWarmIP(subnet, ip string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.subnetWarmIPs[subnet] = append(ds.subnetWarmIPs[subnet], ip)
+}
+
+// AssignPodIPv4AddressFromSubnet assigns pod a secondary IP from the named
+// subnet's own pool instead of the instance's default warm pool.
+func (ds *DataStore) AssignPodIPv4AddressFromSubnet(pod *k8sapi.K8SPodInfo, subnet string) (string, int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ips := ds.subnetWarmIPs[subnet]
+	if len(ips) == 0 {
+		return "", 0, ErrNoAvailableIPAddress
+	}
+
+	ip := ips[len(ips)-1]
+	ds.subnetWarmIPs[subnet] = ips[:len(ips)-1]
+	deviceNumber := ds.allocDeviceNumber()
+
+	ds.podIPs[key(pod)] = ipAssignment{ip: ip, deviceNumber: deviceNumber, container: pod.Container, subnet: subnet}
+	return ip, deviceNumber, nil
+}
+
+// releaseToPool returns assignment's IP to the pool it came from: the named
+// subnet pool it was assigned from, or the default warm pool otherwise.
+func (ds *DataStore) releaseToPool(assignment ipAssignment) {
+	if assignment.subnet != "" {
+		ds.subnetWarmIPs[assignment.subnet] = append(ds.subnetWarmIPs[assignment.subnet], assignment.ip)
+		return
+	}
+	ds.warmIPs = append(ds.warmIPs, assignment.ip)
+}
+
+// UnassignPodIPv4Address removes pod's binding and returns its IP to the
+// pool it came from. If pod.Container is set and doesn't match the bound
+// container, or no binding exists at all, ErrUnknownPod is returned.
+func (ds *DataStore) UnassignPodIPv4Address(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	assignment, ok := ds.podIPs[key(pod)]
+	if !ok {
+		return "", 0, ErrUnknownPod
+	}
+	if pod.Container != "" && assignment.container != "" && assignment.container != pod.Container {
+		return "", 0, ErrUnknownPod
+	}
+
+	delete(ds.podIPs, key(pod))
+	ds.freeDeviceNumber(assignment.deviceNumber)
+	ds.releaseToPool(assignment)
+	return assignment.ip, assignment.deviceNumber, nil
+}
+
+// MarkPodIPForRelease removes pod's binding from the active pool, like
+// UnassignPodIPv4Address, but holds the IP in a pending-release state
+// instead of returning it to warmIPs. Call FreeReleasedPodIP once the CNI
+// plugin has confirmed the pod's network resources are torn down to
+// actually make the IP available for reuse.
+func (ds *DataStore) MarkPodIPForRelease(pod *k8sapi.K8SPodInfo) (string, int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	assignment, ok := ds.podIPs[key(pod)]
+	if !ok {
+		return "", 0, ErrUnknownPod
+	}
+	if pod.Container != "" && assignment.container != "" && assignment.container != pod.Container {
+		return "", 0, ErrUnknownPod
+	}
+
+	delete(ds.podIPs, key(pod))
+	ds.pendingRelease[key(pod)] = assignment
+	return assignment.ip, assignment.deviceNumber, nil
+}
+
+// FreeReleasedPodIP returns a pending-release IP, previously marked by
+// MarkPodIPForRelease, to the warm pool.
+func (ds *DataStore) FreeReleasedPodIP(pod *k8sapi.K8SPodInfo) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	assignment, ok := ds.pendingRelease[key(pod)]
+	if !ok {
+		return ErrUnknownPod
+	}
+
+	delete(ds.pendingRelease, key(pod))
+	ds.freeDeviceNumber(assignment.deviceNumber)
+	ds.releaseToPool(assignment)
+	return nil
+}
+
+// ReconcileLeakedIPs releases warm-pool bindings that no longer match
+// liveContainers, the authoritative "namespace/name" -> sandbox container ID
+// map kubelet currently reports. A name absent from liveContainers means the
+// pod is gone; a name present but with a different container ID means the
+// pod was recreated and ipamd never saw the old sandbox's DelNetwork. Either
+// way the binding is stale and its IP is released. It returns the IPs it
+// released, for logging.
+func (ds *DataStore) ReconcileLeakedIPs(liveContainers map[string]string) []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var released []string
+	for k, assignment := range ds.podIPs {
+		containerID, live := liveContainers[k.namespace+"/"+k.name]
+		if live && (assignment.container == "" || containerID == "" || assignment.container == containerID) {
+			continue
+		}
+		delete(ds.podIPs, k)
+		ds.freeDeviceNumber(assignment.deviceNumber)
+		ds.releaseToPool(assignment)
+		released = append(released, assignment.ip)
+	}
+	return released
+}
+
+// AddBranchENIPod records that pod owns the branch ENI eniID.
+func (ds *DataStore) AddBranchENIPod(pod *k8sapi.K8SPodInfo, eniID string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.branchENIs[key(pod)] = eniID
+}
+
+// IsBranchENIPod reports whether pod was assigned a branch ENI rather than a
+// secondary IP from the warm pool.
+func (ds *DataStore) IsBranchENIPod(pod *k8sapi.K8SPodInfo) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, ok := ds.branchENIs[key(pod)]
+	return ok
+}
+
+// DelBranchENIPod removes pod's branch ENI binding and returns the ENI ID so
+// the caller can disassociate and delete it.
+func (ds *DataStore) DelBranchENIPod(pod *k8sapi.K8SPodInfo) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	eniID, ok := ds.branchENIs[key(pod)]
+	if !ok {
+		return "", ErrUnknownPod
+	}
+	delete(ds.branchENIs, key(pod))
+	return eniID, nil
+}
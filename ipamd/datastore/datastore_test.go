@@ -0,0 +1,181 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+)
+
+func TestMarkPodIPForRelease_HoldsIPUntilFreed(t *testing.T) {
+	ds := NewDataStore()
+	ds.AddWarmIP("10.0.0.1")
+
+	podA := &k8sapi.K8SPodInfo{Name: "a", Namespace: "ns", Container: "cidA"}
+	ip, _, err := ds.AssignPodIPv4Address(podA)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip)
+
+	// Del for pod A marks the IP pending release, it must NOT be handed out
+	// to a new pod (e.g. an immediate Add for a different pod) until the CNI
+	// plugin confirms teardown via FreeReleasedPodIP.
+	releasedIP, _, err := ds.MarkPodIPForRelease(podA)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", releasedIP)
+
+	podB := &k8sapi.K8SPodInfo{Name: "b", Namespace: "ns", Container: "cidB"}
+	_, _, err = ds.AssignPodIPv4Address(podB)
+	assert.Equal(t, ErrNoAvailableIPAddress, err, "IP must stay pending, not be reusable before FreeReleasedPodIP")
+
+	assert.NoError(t, ds.FreeReleasedPodIP(podA))
+
+	ip, _, err = ds.AssignPodIPv4Address(podB)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip, "IP should be reusable once resources were confirmed torn down")
+}
+
+func TestMarkPodIPForRelease_UnknownPod(t *testing.T) {
+	ds := NewDataStore()
+	_, _, err := ds.MarkPodIPForRelease(&k8sapi.K8SPodInfo{Name: "ghost", Namespace: "ns"})
+	assert.Equal(t, ErrUnknownPod, err)
+}
+
+func TestFreeReleasedPodIP_WithoutPendingRelease(t *testing.T) {
+	ds := NewDataStore()
+	err := ds.FreeReleasedPodIP(&k8sapi.K8SPodInfo{Name: "a", Namespace: "ns"})
+	assert.Equal(t, ErrUnknownPod, err)
+}
+
+func TestAddDelInterleavedForSameIP(t *testing.T) {
+	ds := NewDataStore()
+	ds.AddWarmIP("10.0.0.5")
+
+	for i := 0; i < 3; i++ {
+		pod := &k8sapi.K8SPodInfo{Name: "churn", Namespace: "ns", Container: "cid"}
+		ip, _, err := ds.AssignPodIPv4Address(pod)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.5", ip)
+
+		_, _, err = ds.MarkPodIPForRelease(pod)
+		assert.NoError(t, err)
+		assert.NoError(t, ds.FreeReleasedPodIP(pod))
+	}
+}
+
+func TestAssignPodIPv4AddressFromSubnet_ReleasesToSubnetPoolNotDefault(t *testing.T) {
+	ds := NewDataStore()
+	ds.AddWarmIP("10.0.0.1")
+	ds.AddSubnetWarmIP("subnet-a", "10.1.0.1")
+
+	pod := &k8sapi.K8SPodInfo{Name: "a", Namespace: "ns", Container: "cid"}
+	ip, _, err := ds.AssignPodIPv4AddressFromSubnet(pod, "subnet-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.1", ip)
+
+	// The default warm pool must be untouched by a subnet-pool assignment.
+	other := &k8sapi.K8SPodInfo{Name: "b", Namespace: "ns", Container: "cid"}
+	ip, _, err = ds.AssignPodIPv4Address(other)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip)
+
+	// Releasing the subnet IP must return it to the subnet pool, not the
+	// default warm pool.
+	_, _, err = ds.UnassignPodIPv4Address(pod)
+	assert.NoError(t, err)
+
+	ip, _, err = ds.AssignPodIPv4AddressFromSubnet(&k8sapi.K8SPodInfo{Name: "c", Namespace: "ns"}, "subnet-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.1", ip)
+}
+
+func TestAssignPodIPv4Address_NoDeviceNumberCollisionAfterRelease(t *testing.T) {
+	ds := NewDataStore()
+	ds.AddWarmIP("10.0.0.1")
+	ds.AddWarmIP("10.0.0.2")
+	ds.AddWarmIP("10.0.0.3")
+
+	podA := &k8sapi.K8SPodInfo{Name: "a", Namespace: "ns", Container: "cidA"}
+	podB := &k8sapi.K8SPodInfo{Name: "b", Namespace: "ns", Container: "cidB"}
+	_, deviceA, err := ds.AssignPodIPv4Address(podA)
+	assert.NoError(t, err)
+	_, deviceB, err := ds.AssignPodIPv4Address(podB)
+	assert.NoError(t, err)
+	assert.NotEqual(t, deviceA, deviceB, "two live pods must never share a device number")
+
+	// Releasing A's device number must not let a third pod collide with B,
+	// which is still live.
+	_, _, err = ds.UnassignPodIPv4Address(podA)
+	assert.NoError(t, err)
+
+	podC := &k8sapi.K8SPodInfo{Name: "c", Namespace: "ns", Container: "cidC"}
+	_, deviceC, err := ds.AssignPodIPv4Address(podC)
+	assert.NoError(t, err)
+	assert.NotEqual(t, deviceB, deviceC, "B is still live, C must not reuse its device number")
+	assert.Equal(t, deviceA, deviceC, "C should reuse A's now-free device number rather than growing indefinitely")
+}
+
+func TestGCRetainedIPs_ReclaimsToSubnetPoolNotDefault(t *testing.T) {
+	ds := NewDataStore()
+	ds.checkpointPath = t.TempDir() + "/retained-ips.json"
+	ds.AddSubnetWarmIP("subnet-a", "10.1.0.1")
+
+	pod := &k8sapi.K8SPodInfo{Name: "a", Namespace: "ns", Container: "cid"}
+	ip, _, err := ds.AssignPodIPv4AddressFromSubnet(pod, "subnet-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.1", ip)
+
+	_, _, err = ds.RetainPodIPv4Address(pod)
+	assert.NoError(t, err)
+
+	// Pod never comes back; GC after the TTL must return the IP to its
+	// origin subnet pool, not the default warm pool.
+	reclaimed := ds.GCRetainedIPs(time.Now().Add(2 * retainedIPTTL))
+	assert.Equal(t, 1, reclaimed)
+
+	other := &k8sapi.K8SPodInfo{Name: "b", Namespace: "ns", Container: "cid"}
+	ip, _, err = ds.AssignPodIPv4AddressFromSubnet(other, "subnet-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.1", ip)
+	assert.Empty(t, ds.warmIPs, "reclaimed subnet IP must not have landed in the default warm pool")
+}
+
+func TestReconcileLeakedIPs_DetectsRecreatedSandboxByContainerID(t *testing.T) {
+	ds := NewDataStore()
+	ds.AddWarmIP("10.0.0.1")
+	ds.AddWarmIP("10.0.0.2")
+
+	stale := &k8sapi.K8SPodInfo{Name: "a", Namespace: "ns", Container: "cid-old"}
+	ip, _, err := ds.AssignPodIPv4Address(stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip)
+
+	current := &k8sapi.K8SPodInfo{Name: "b", Namespace: "ns", Container: "cid-current"}
+	_, _, err = ds.AssignPodIPv4Address(current)
+	assert.NoError(t, err)
+
+	// kubelet reports "ns/a" as live, but with a different container ID than
+	// the binding's: the old sandbox was torn down and a new one with the
+	// same name took its place without ipamd ever seeing a DelNetwork for the
+	// old one, so the binding must still be treated as leaked.
+	live := map[string]string{
+		"ns/a": "cid-new",
+		"ns/b": "cid-current",
+	}
+	released := ds.ReconcileLeakedIPs(live)
+	assert.Equal(t, []string{"10.0.0.1"}, released)
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ipamd implements the long-running daemon that manages ENIs and IP
+// addresses on the instance and hands them out to pods over a local gRPC API.
+package ipamd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/aws/amazon-vpc-cni-k8s/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// retainedIPGCInterval is how often the retained-IP checkpoint is swept for
+// stale bindings whose pod never came back.
+const retainedIPGCInterval = 10 * time.Minute
+
+// podIPReconcileInterval is how often reconcilePodIPsLoop checks the
+// datastore's pod IP bindings against kubelet's authoritative pod list.
+const podIPReconcileInterval = 30 * time.Second
+
+// IPAMContext holds the state ipamd needs to answer CNI Add/Del requests:
+// the ENI/IP datastore, the AWS and networking clients, and the pool of
+// trunk/branch ENIs used for per-pod security groups.
+type IPAMContext struct {
+	dataStore     *datastore.DataStore
+	awsClient     awsutils.APIs
+	networkClient networkutils.NetworkAPIs
+
+	// k8sClient is used to fetch a pod's annotations when handling
+	// AddNetwork, e.g. vpc.amazonaws.com/pod-security-groups, so features
+	// like per-pod security groups are driven by what the pod actually asked
+	// for rather than a proto field nothing ever sets.
+	k8sClient k8sapi.K8SAPIs
+
+	// podResourcesClient is used by reconcilePodIPsLoop to check datastore
+	// bindings against kubelet's authoritative pod list. It's nil until
+	// connectPodResourcesClient succeeds.
+	podResourcesClient *k8sapi.PodResourcesClient
+
+	// subnetENIs caches the ENIs allocated for each named subnet a pod has
+	// asked to be placed on, so repeat requests for the same subnet reuse
+	// its pool instead of allocating a new ENI every time. More than one ENI
+	// can accumulate per subnet once its existing ENIs' IPs are all handed
+	// out; see allocSubnetENI.
+	subnetENIsMu sync.Mutex
+	subnetENIs   map[string][]*awsutils.ENIMetadata
+
+	terminating int32 // accessed atomically, set by shutdownListener
+}
+
+// NewIPAMContext creates an IPAMContext backed by the given AWS, networking
+// and k8s API clients.
+func NewIPAMContext(awsClient awsutils.APIs, networkClient networkutils.NetworkAPIs, k8sClient k8sapi.K8SAPIs) *IPAMContext {
+	c := &IPAMContext{
+		dataStore:     datastore.NewDataStore(),
+		awsClient:     awsClient,
+		networkClient: networkClient,
+		k8sClient:     k8sClient,
+		subnetENIs:    make(map[string][]*awsutils.ENIMetadata),
+	}
+	if err := c.dataStore.LoadRetainedIPCheckpoint(); err != nil {
+		log.Errorf("Failed to load retained IP checkpoint, sticky IPs will not survive this restart: %v", err)
+	}
+	return c
+}
+
+// runRetainedIPGCLoop periodically reclaims retained IP bindings whose pod
+// never came back within the TTL, returning their IPs to the warm pool.
+func (c *IPAMContext) runRetainedIPGCLoop() {
+	ticker := time.NewTicker(retainedIPGCInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.dataStore.GCRetainedIPs(time.Now())
+	}
+}
+
+// connectPodResourcesClient dials kubelet's PodResources gRPC socket so
+// reconcilePodIPsLoop can check datastore bindings against the pods kubelet
+// actually knows about.
+func (c *IPAMContext) connectPodResourcesClient() error {
+	client, err := k8sapi.NewPodResourcesClient()
+	if err != nil {
+		return err
+	}
+	c.podResourcesClient = client
+	return nil
+}
+
+// reconcilePodIPsLoop periodically compares the datastore's pod IP bindings
+// against kubelet's authoritative list of running pod sandboxes, and
+// releases any binding whose pod kubelet no longer knows about back to the
+// warm pool. This is what cleans up IPs left bound to pods that disappeared
+// while ipamd was down or missed their DelNetwork call, instead of the
+// binding leaking forever.
+func (c *IPAMContext) reconcilePodIPsLoop() {
+	ticker := time.NewTicker(podIPReconcileInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sandboxes, err := c.podResourcesClient.ListPodSandboxes(context.Background())
+		if err != nil {
+			log.Warnf("Failed to list pod sandboxes from kubelet PodResources, skipping this reconciliation pass: %v", err)
+			continue
+		}
+
+		// PodSandbox.ContainerID is a container name, not the sandbox ID
+		// bindings are keyed by, so only the pod's presence is checked.
+		live := make(map[string]string, len(sandboxes))
+		for _, sandbox := range sandboxes {
+			live[sandbox.Namespace+"/"+sandbox.Name] = ""
+		}
+
+		for _, ip := range c.dataStore.ReconcileLeakedIPs(live) {
+			log.Infof("Reconciliation released leaked IP %s: pod no longer known to kubelet", ip)
+		}
+	}
+}
+
+// setTerminating marks the node as shutting down, e.g. so that health checks
+// can start failing ahead of pod eviction.
+func (c *IPAMContext) setTerminating() {
+	atomic.StoreInt32(&c.terminating, 1)
+}
+
+// isTerminating reports whether setTerminating has been called.
+func (c *IPAMContext) isTerminating() bool {
+	return atomic.LoadInt32(&c.terminating) == 1
+}
+
+// EnsureTrunkENI makes sure the instance has a trunk ENI available for
+// branch-ENI allocation, creating one if necessary. It should be called once
+// at startup; AllocBranchENI also calls GetTrunkENI lazily in case the first
+// attempt failed.
+func (c *IPAMContext) EnsureTrunkENI() error {
+	_, err := c.awsClient.GetTrunkENI()
+	return err
+}
+
+// AssignPodBranchENI gives pod a branch ENI with the requested security
+// groups, attached to the instance's trunk ENI on its own VLAN.
+func (c *IPAMContext) AssignPodBranchENI(pod *k8sapi.K8SPodInfo, securityGroups []string) (*awsutils.ENIMetadata, int, error) {
+	eni, vlanID, err := c.awsClient.AllocBranchENI(securityGroups)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.dataStore.AddBranchENIPod(pod, eni.ENIID)
+	log.Infof("Assigned branch ENI %s (VLAN %d) to pod %s/%s", eni.ENIID, vlanID, pod.Namespace, pod.Name)
+	return eni, vlanID, nil
+}
+
+// AssignPodIPv4AddressFromSubnet gives pod an IP from subnetID's own ENI
+// pool rather than the instance's default warm pool, allocating an ENI in
+// that subnet the first time it's requested and an additional one whenever
+// the existing ENIs' secondary IPs have all been handed out.
+func (c *IPAMContext) AssignPodIPv4AddressFromSubnet(pod *k8sapi.K8SPodInfo, subnetID string) (string, int, error) {
+	if err := c.ensureSubnetENI(subnetID); err != nil {
+		return "", 0, err
+	}
+
+	addr, deviceNumber, err := c.dataStore.AssignPodIPv4AddressFromSubnet(pod, subnetID)
+	if err == datastore.ErrNoAvailableIPAddress {
+		// The subnet's ENI(s) are fully handed out; grow the pool with
+		// another ENI in the same subnet instead of failing the pod.
+		if allocErr := c.allocSubnetENI(subnetID); allocErr != nil {
+			return "", 0, err
+		}
+		addr, deviceNumber, err = c.dataStore.AssignPodIPv4AddressFromSubnet(pod, subnetID)
+	}
+	return addr, deviceNumber, err
+}
+
+// ensureSubnetENI makes sure at least one ENI has been allocated in subnetID,
+// allocating one on demand if this is the first request for it.
+func (c *IPAMContext) ensureSubnetENI(subnetID string) error {
+	c.subnetENIsMu.Lock()
+	_, ok := c.subnetENIs[subnetID]
+	c.subnetENIsMu.Unlock()
+	if ok {
+		return nil
+	}
+	return c.allocSubnetENI(subnetID)
+}
+
+// allocSubnetENI allocates a new ENI in subnetID, adds it to that subnet's
+// pool and seeds its IPv4 addresses into the datastore's pool for the subnet.
+// AllocSubnetENI assigns no secondary IPs, so each ENI adds only its primary
+// private IP.
+func (c *IPAMContext) allocSubnetENI(subnetID string) error {
+	eni, err := c.awsClient.AllocSubnetENI(subnetID)
+	if err != nil {
+		return err
+	}
+
+	c.subnetENIsMu.Lock()
+	c.subnetENIs[subnetID] = append(c.subnetENIs[subnetID], eni)
+	c.subnetENIsMu.Unlock()
+
+	for _, ip := range eni.IPv4Addresses {
+		c.dataStore.AddSubnetWarmIP(subnetID, ip)
+	}
+	log.Infof("Allocated ENI %s for subnet pool %s", eni.ENIID, subnetID)
+	return nil
+}
+
+// UnassignPodBranchENI releases the branch ENI bound to pod, disassociating
+// it from the trunk and deleting it.
+func (c *IPAMContext) UnassignPodBranchENI(pod *k8sapi.K8SPodInfo) error {
+	eniID, err := c.dataStore.DelBranchENIPod(pod)
+	if err != nil {
+		return err
+	}
+	return c.awsClient.FreeBranchENI(eniID)
+}
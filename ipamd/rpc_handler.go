@@ -17,6 +17,8 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/pkg/errors"
@@ -32,11 +34,18 @@ import (
 	log "github.com/cihub/seelog"
 
 	"github.com/aws/amazon-vpc-cni-k8s/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 )
 
 const (
 	ipamdgRPCaddress = "127.0.0.1:50051"
+
+	// podRestartPreserveIPReason is the DelNetworkRequest reason that makes
+	// DelNetwork keep the pod's IP in the retained set, so that a pod
+	// recreated with the same name/namespace can get it back. The routed-eni
+	// plugin's cmdDel sends no Reason.
+	podRestartPreserveIPReason = "PodRestartPreserveIP"
 )
 
 // server controls RPC service responses.
@@ -46,13 +55,71 @@ type server struct {
 
 // AddNetwork processes CNI add network request and return an IP address for container
 func (s *server) AddNetwork(ctx context.Context, in *pb.AddNetworkRequest) (*pb.AddNetworkReply, error) {
-	log.Infof("Received AddNetwork for NS %s, Pod %s, NameSpace %s, Container %s, ifname %s",
-		in.Netns, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID, in.IfName)
+	log.Infof("Received AddNetwork for NS %s, Pod %s, NameSpace %s, Container %s, ifname %s, SecurityGroups %v, PodSubnet %s",
+		in.Netns, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID, in.IfName, in.SecurityGroups, in.PodSubnet)
 
-	addr, deviceNumber, err := s.ipamContext.dataStore.AssignPodIPv4Address(&k8sapi.K8SPodInfo{
+	podInfo := &k8sapi.K8SPodInfo{
 		Name:      in.K8S_POD_NAME,
 		Namespace: in.K8S_POD_NAMESPACE,
-		Container: in.K8S_POD_INFRA_CONTAINER_ID})
+		Container: in.K8S_POD_INFRA_CONTAINER_ID}
+
+	securityGroups := in.SecurityGroups
+	podSubnet := in.PodSubnet
+	retainIP := in.RetainIP
+
+	if s.ipamContext.k8sClient != nil {
+		annotations, err := s.ipamContext.k8sClient.GetPodAnnotations(in.K8S_POD_NAMESPACE, in.K8S_POD_NAME)
+		if err != nil {
+			log.Warnf("Failed to fetch annotations for pod %s/%s, falling back to AddNetworkRequest fields: %v", in.K8S_POD_NAMESPACE, in.K8S_POD_NAME, err)
+		} else {
+			if v, ok := annotations[k8sapi.SecurityGroupsAnnotation]; ok {
+				securityGroups = strings.Split(v, ",")
+			}
+			if v, ok := annotations[k8sapi.PodSubnetAnnotation]; ok {
+				podSubnet = v
+			}
+			if v, ok := annotations[k8sapi.RetainIPAnnotation]; ok {
+				if parsed, err := strconv.ParseBool(v); err == nil {
+					retainIP = parsed
+				} else {
+					log.Warnf("Pod %s/%s has non-boolean %s annotation %q, ignoring", in.K8S_POD_NAMESPACE, in.K8S_POD_NAME, k8sapi.RetainIPAnnotation, v)
+				}
+			}
+		}
+	}
+
+	var (
+		addr         string
+		deviceNumber int
+		subnet       string
+		err          error
+		branchENI    *awsutils.ENIMetadata
+		vlanID       int
+	)
+
+	if len(securityGroups) > 0 {
+		// The pod asked for its own security groups, so hand it a branch ENI off
+		// the instance's trunk ENI instead of a secondary IP from the warm pool.
+		branchENI, vlanID, err = s.ipamContext.AssignPodBranchENI(podInfo, securityGroups)
+		if branchENI != nil {
+			addr = branchENI.IPv4Addresses[0]
+		}
+	} else if podSubnet != "" {
+		// The pod asked to be placed on a specific named subnet/CIDR pool, e.g.
+		// for a dedicated route table or SG policy, so satisfy it from that
+		// subnet's own ENI pool instead of the default warm pool.
+		addr, deviceNumber, err = s.ipamContext.AssignPodIPv4AddressFromSubnet(podInfo, podSubnet)
+		if err == nil {
+			subnet = podSubnet
+		}
+	} else if retainIP {
+		// The pod wants to keep the same IPv4 address across restarts (e.g. a
+		// KubeVirt VirtualMachineInstance), so look for a sticky binding for
+		// this namespace/name before falling back to a fresh warm-pool IP.
+		addr, deviceNumber, err = s.ipamContext.dataStore.AssignPodIPv4AddressRetained(podInfo)
+	} else {
+		addr, deviceNumber, err = s.ipamContext.dataStore.AssignPodIPv4Address(podInfo)
+	}
 
 	var pbVPCcidrs []string
 	for _, cidr := range s.ipamContext.awsClient.GetVPCIPv4CIDRs() {
@@ -71,32 +138,67 @@ func (s *server) AddNetwork(ctx context.Context, in *pb.AddNetworkRequest) (*pb.
 	resp := pb.AddNetworkReply{
 		Success:         err == nil,
 		IPv4Addr:        addr,
-		IPv4Subnet:      "",
+		IPv4Subnet:      subnet,
 		DeviceNumber:    int32(deviceNumber),
 		UseExternalSNAT: useExternalSNAT,
 		VPCcidrs:        pbVPCcidrs,
 	}
+	if branchENI != nil {
+		resp.VlanID = int32(vlanID)
+		resp.BranchENIMAC = branchENI.MAC
+		resp.BranchENISubnetGW = branchENI.SubnetIPv4CIDR
+	}
 
-	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", addr, deviceNumber, err)
+	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, VlanID: %d, err: %v", addr, deviceNumber, vlanID, err)
 	addIPCnt.Inc()
 	return &resp, nil
 }
 
+// DelNetwork processes CNI del network request. It marks the pod's IP as
+// pending release and returns; the IP is only returned to the warm pool when
+// the CNI plugin later calls ReleaseIPResources to confirm that the pod's
+// netlink/iptables resources have been torn down. This closes the window where a fresh AddNetwork
+// could reuse the IP while the previous pod's route rules or SNAT exclusions
+// are still in place.
 func (s *server) DelNetwork(ctx context.Context, in *pb.DelNetworkRequest) (*pb.DelNetworkReply, error) {
 	log.Infof("Received DelNetwork for IP %s, Pod %s, Namespace %s, Container %s",
 		in.IPv4Addr, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, in.K8S_POD_INFRA_CONTAINER_ID)
 	delIPCnt.With(prometheus.Labels{"reason": in.Reason}).Inc()
 
-	ip, deviceNumber, err := s.ipamContext.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{
+	podInfo := &k8sapi.K8SPodInfo{
 		Name:      in.K8S_POD_NAME,
 		Namespace: in.K8S_POD_NAMESPACE,
-		Container: in.K8S_POD_INFRA_CONTAINER_ID})
+		Container: in.K8S_POD_INFRA_CONTAINER_ID}
+
+	if s.ipamContext.dataStore.IsBranchENIPod(podInfo) {
+		// Branch ENIs live in their own pool, separate from the secondary-IP
+		// warm pool, so they need to be disassociated from the trunk and
+		// deleted rather than returned to the datastore for reuse.
+		if err := s.ipamContext.UnassignPodBranchENI(podInfo); err != nil {
+			log.Errorf("Failed to release branch ENI for pod %s/%s: %v", in.K8S_POD_NAMESPACE, in.K8S_POD_NAME, err)
+			return &pb.DelNetworkReply{Success: false}, nil
+		}
+		return &pb.DelNetworkReply{Success: true}, nil
+	}
 
-	if err != nil && err == datastore.ErrUnknownPod {
-		// If L-IPAMD restarts, the pod's IP address are assigned by only pod's name and namespace due to kubelet's introspection.
-		ip, deviceNumber, err = s.ipamContext.dataStore.UnassignPodIPv4Address(&k8sapi.K8SPodInfo{
-			Name:      in.K8S_POD_NAME,
-			Namespace: in.K8S_POD_NAMESPACE})
+	if in.Reason == podRestartPreserveIPReason {
+		// The pod is being recreated with the same name/namespace and wants to
+		// keep its IP (see RetainIP on AddNetwork), so soft-release it into the
+		// sticky checkpoint instead of returning it to the warm pool.
+		ip, deviceNumber, err := s.ipamContext.dataStore.RetainPodIPv4Address(podInfo)
+		log.Infof("Send DelNetworkReply (retained): IPv4Addr %s, DeviceNumber: %d, err: %v", ip, deviceNumber, err)
+		return &pb.DelNetworkReply{Success: err == nil, IPv4Addr: ip, DeviceNumber: int32(deviceNumber)}, nil
+	}
+
+	ip, deviceNumber, err := s.ipamContext.dataStore.MarkPodIPForRelease(podInfo)
+
+	if err == datastore.ErrUnknownPod {
+		// ipamd has no binding for this pod, either because it never got a
+		// matching AddNetwork (e.g. ipamd restarted mid-sandbox-creation) or
+		// because reconcilePodIPsLoop's periodic sweep against kubelet's
+		// authoritative pod list already reclaimed it as leaked. Either way
+		// there's nothing left here to release.
+		log.Warnf("No IP binding found for pod %s/%s, nothing to release", in.K8S_POD_NAMESPACE, in.K8S_POD_NAME)
 	}
 	log.Infof("Send DelNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", ip, deviceNumber, err)
 
@@ -107,13 +209,50 @@ func (s *server) DelNetwork(ctx context.Context, in *pb.DelNetworkRequest) (*pb.
 	if err != nil && err != datastore.ErrUnknownPod {
 		success = false
 	}
-	return &pb.DelNetworkReply{Success: success, IPv4Addr: ip, DeviceNumber: int32(deviceNumber)}, nil
+	return &pb.DelNetworkReply{
+		Success:        success,
+		IPv4Addr:       ip,
+		DeviceNumber:   int32(deviceNumber),
+		ReleasePending: err == nil,
+	}, nil
+}
+
+// ReleaseIPResources is the second phase of IP release: the CNI plugin calls
+// it once it has confirmed that the pod's route rules, iptables SNAT
+// exclusions and veth have been torn down, and only then is the IP actually
+// freed back to the warm pool for reuse by a future AddNetwork.
+func (s *server) ReleaseIPResources(ctx context.Context, in *pb.ReleaseIPResourcesRequest) (*pb.ReleaseIPResourcesReply, error) {
+	log.Infof("Received ReleaseIPResources for IP %s, Pod %s, Namespace %s",
+		in.IPv4Addr, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE)
+
+	err := s.ipamContext.dataStore.FreeReleasedPodIP(&k8sapi.K8SPodInfo{
+		Name:      in.K8S_POD_NAME,
+		Namespace: in.K8S_POD_NAMESPACE})
+	if err != nil {
+		log.Errorf("Failed to free IP %s pending release: %v", in.IPv4Addr, err)
+		return &pb.ReleaseIPResourcesReply{Success: false}, nil
+	}
+	return &pb.ReleaseIPResourcesReply{Success: true}, nil
 }
 
 // RunRPCHandler handles request from gRPC
 func (c *IPAMContext) RunRPCHandler() error {
 	log.Info("Serving RPC Handler on ", ipamdgRPCaddress)
 
+	if err := c.EnsureTrunkENI(); err != nil {
+		// Not fatal: AddNetwork requests for security groups retry creating
+		// the trunk ENI and fail if it still can't be created.
+		log.Warnf("Trunk ENI not available, per-pod security group requests will fail until one can be created: %v", err)
+	}
+
+	go c.runRetainedIPGCLoop()
+
+	if err := c.connectPodResourcesClient(); err != nil {
+		log.Warnf("Failed to dial kubelet PodResources gRPC socket, periodic reconciliation of leaked IPs will be skipped: %v", err)
+	} else {
+		go c.reconcilePodIPsLoop()
+	}
+
 	lis, err := net.Listen("tcp", ipamdgRPCaddress)
 	if err != nil {
 		log.Errorf("Failed to listen gRPC port: %v", err)
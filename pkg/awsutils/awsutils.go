@@ -0,0 +1,273 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package awsutils wraps the EC2 and EC2 instance metadata calls ipamd needs
+// to discover and manage ENIs on the instance.
+package awsutils
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+
+	log "github.com/cihub/seelog"
+)
+
+// ErrNoTrunkENI is returned when the instance has no trunk ENI and one could
+// not be created, e.g. because the instance type doesn't support it.
+var ErrNoTrunkENI = errors.New("awsutils: instance has no trunk ENI")
+
+// ENIMetadata holds the subset of an ENI's EC2 metadata that ipamd needs to
+// hand off to the CNI plugin.
+type ENIMetadata struct {
+	// ENIID is the ENI's EC2 resource ID.
+	ENIID string
+	// MAC is the ENI's MAC address, used by the CNI plugin to find the
+	// corresponding host-side interface.
+	MAC string
+	// DeviceNumber is the ENI's device index on the instance.
+	DeviceNumber int
+	// SubnetIPv4CIDR holds the ID of the subnet the ENI was created in, as
+	// returned by CreateNetworkInterface; it is not the subnet's CIDR.
+	SubnetIPv4CIDR string
+	// IPv4Addresses are the private IPv4 addresses assigned to the ENI, primary first.
+	IPv4Addresses []string
+}
+
+// APIs defines the EC2/IMDS operations ipamd needs.
+type APIs interface {
+	// GetVPCIPv4CIDRs returns the VPC's CIDR blocks.
+	GetVPCIPv4CIDRs() []*string
+	// GetPrimaryENI returns the ENI ID of the instance's primary (device 0) ENI.
+	GetPrimaryENI() string
+	// GetTrunkENI returns the instance's trunk ENI, allocating one if it
+	// doesn't already have one. Not all instance types support trunking.
+	GetTrunkENI() (*ENIMetadata, error)
+	// AllocBranchENI creates a branch ENI with the given security groups,
+	// associates it with the instance's trunk ENI on a free VLAN ID, and
+	// returns the branch ENI's metadata along with the VLAN ID it was
+	// associated on.
+	AllocBranchENI(securityGroups []string) (eni *ENIMetadata, vlanID int, err error)
+	// FreeBranchENI disassociates and deletes a branch ENI previously
+	// returned by AllocBranchENI.
+	FreeBranchENI(eniID string) error
+	// AllocSubnetENI creates an ENI in the given subnet, attaches it to the
+	// instance, and returns its metadata. It's used to satisfy a pod's
+	// request to be placed on a specific named subnet rather than the
+	// instance's default warm pool.
+	AllocSubnetENI(subnetID string) (*ENIMetadata, error)
+}
+
+// EC2InstanceMetadataCache implements APIs against the EC2 API and the
+// instance metadata service, caching what it can to avoid throttling.
+type EC2InstanceMetadataCache struct {
+	mu sync.Mutex
+
+	ec2Client ec2ClientWrapper
+
+	instanceID string
+	primaryENI string
+	vpcCIDRs   []*string
+
+	trunkENI       *ENIMetadata
+	nextVlanID     int
+	branchENIVlan  map[string]int    // ENI ID -> VLAN ID
+	branchENIAssoc map[string]string // ENI ID -> trunk AssociationId, needed to disassociate
+
+	nextDeviceNumber int // next device index for AllocSubnetENI, starting at 1 after the primary ENI
+}
+
+// ec2ClientWrapper is the narrow slice of the EC2 SDK client that
+// EC2InstanceMetadataCache calls into; split out so tests can fake it.
+type ec2ClientWrapper interface {
+	CreateNetworkInterface(*ec2.CreateNetworkInterfaceInput) (*ec2.CreateNetworkInterfaceOutput, error)
+	DeleteNetworkInterface(*ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error)
+	AssociateTrunkInterface(*ec2.AssociateTrunkInterfaceInput) (*ec2.AssociateTrunkInterfaceOutput, error)
+	DisassociateTrunkInterface(*ec2.DisassociateTrunkInterfaceInput) (*ec2.DisassociateTrunkInterfaceOutput, error)
+	AttachNetworkInterface(*ec2.AttachNetworkInterfaceInput) (*ec2.AttachNetworkInterfaceOutput, error)
+}
+
+// New creates an EC2InstanceMetadataCache backed by the given EC2 client.
+func New(ec2Client ec2ClientWrapper) *EC2InstanceMetadataCache {
+	return &EC2InstanceMetadataCache{
+		ec2Client:        ec2Client,
+		branchENIVlan:    make(map[string]int),
+		branchENIAssoc:   make(map[string]string),
+		nextVlanID:       1,
+		nextDeviceNumber: 1,
+	}
+}
+
+// GetVPCIPv4CIDRs returns the VPC's CIDR blocks.
+func (cache *EC2InstanceMetadataCache) GetVPCIPv4CIDRs() []*string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.vpcCIDRs
+}
+
+// GetPrimaryENI returns the ENI ID of the instance's primary ENI.
+func (cache *EC2InstanceMetadataCache) GetPrimaryENI() string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.primaryENI
+}
+
+// GetTrunkENI returns the instance's trunk ENI, creating one on demand.
+func (cache *EC2InstanceMetadataCache) GetTrunkENI() (*ENIMetadata, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.trunkENI != nil {
+		return cache.trunkENI, nil
+	}
+
+	out, err := cache.ec2Client.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{
+		Description:   aws.String("aws-k8s-trunk-eni"),
+		InterfaceType: aws.String(ec2.NetworkInterfaceTypeTrunk),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "awsutils: failed to create trunk ENI")
+	}
+	if out.NetworkInterface == nil || out.NetworkInterface.NetworkInterfaceId == nil {
+		return nil, ErrNoTrunkENI
+	}
+
+	cache.trunkENI = &ENIMetadata{ENIID: aws.StringValue(out.NetworkInterface.NetworkInterfaceId)}
+	log.Infof("Created trunk ENI %s", cache.trunkENI.ENIID)
+	return cache.trunkENI, nil
+}
+
+// AllocBranchENI creates a branch ENI with the requested security groups and
+// associates it with the trunk ENI on the next free VLAN ID.
+func (cache *EC2InstanceMetadataCache) AllocBranchENI(securityGroups []string) (*ENIMetadata, int, error) {
+	trunk, err := cache.GetTrunkENI()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groupIds := make([]*string, 0, len(securityGroups))
+	for _, sg := range securityGroups {
+		groupIds = append(groupIds, aws.String(sg))
+	}
+
+	createOut, err := cache.ec2Client.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{
+		Description: aws.String("aws-k8s-branch-eni"),
+		Groups:      groupIds,
+	})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "awsutils: failed to create branch ENI")
+	}
+	branchENIID := aws.StringValue(createOut.NetworkInterface.NetworkInterfaceId)
+
+	cache.mu.Lock()
+	vlanID := cache.nextVlanID
+	cache.nextVlanID++
+	cache.mu.Unlock()
+
+	assocOut, err := cache.ec2Client.AssociateTrunkInterface(&ec2.AssociateTrunkInterfaceInput{
+		BranchInterfaceId: aws.String(branchENIID),
+		TrunkInterfaceId:  aws.String(trunk.ENIID),
+		VlanId:            aws.Int64(int64(vlanID)),
+	})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "awsutils: failed to associate branch ENI with trunk")
+	}
+	if assocOut.InterfaceAssociation == nil || assocOut.InterfaceAssociation.AssociationId == nil {
+		return nil, 0, errors.New("awsutils: AssociateTrunkInterface returned no AssociationId")
+	}
+	associationID := aws.StringValue(assocOut.InterfaceAssociation.AssociationId)
+
+	meta := &ENIMetadata{
+		ENIID:          branchENIID,
+		MAC:            aws.StringValue(createOut.NetworkInterface.MacAddress),
+		SubnetIPv4CIDR: aws.StringValue(createOut.NetworkInterface.SubnetId),
+	}
+	if createOut.NetworkInterface.PrivateIpAddress != nil {
+		meta.IPv4Addresses = []string{aws.StringValue(createOut.NetworkInterface.PrivateIpAddress)}
+	}
+
+	cache.mu.Lock()
+	cache.branchENIVlan[branchENIID] = vlanID
+	cache.branchENIAssoc[branchENIID] = associationID
+	cache.mu.Unlock()
+
+	log.Infof("Allocated branch ENI %s on VLAN %d with security groups %v", branchENIID, vlanID, securityGroups)
+	return meta, vlanID, nil
+}
+
+// AllocSubnetENI creates an ENI in the given subnet and attaches it to the
+// instance on the next free device index.
+func (cache *EC2InstanceMetadataCache) AllocSubnetENI(subnetID string) (*ENIMetadata, error) {
+	createOut, err := cache.ec2Client.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{
+		Description: aws.String("aws-k8s-subnet-eni"),
+		SubnetId:    aws.String(subnetID),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "awsutils: failed to create subnet ENI")
+	}
+	eniID := aws.StringValue(createOut.NetworkInterface.NetworkInterfaceId)
+
+	cache.mu.Lock()
+	deviceNumber := cache.nextDeviceNumber
+	cache.nextDeviceNumber++
+	instanceID := cache.instanceID
+	cache.mu.Unlock()
+
+	if _, err := cache.ec2Client.AttachNetworkInterface(&ec2.AttachNetworkInterfaceInput{
+		NetworkInterfaceId: aws.String(eniID),
+		InstanceId:         aws.String(instanceID),
+		DeviceIndex:        aws.Int64(int64(deviceNumber)),
+	}); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("awsutils: failed to attach subnet ENI %s", eniID))
+	}
+
+	meta := &ENIMetadata{
+		ENIID:          eniID,
+		MAC:            aws.StringValue(createOut.NetworkInterface.MacAddress),
+		DeviceNumber:   deviceNumber,
+		SubnetIPv4CIDR: aws.StringValue(createOut.NetworkInterface.SubnetId),
+	}
+	if createOut.NetworkInterface.PrivateIpAddress != nil {
+		meta.IPv4Addresses = []string{aws.StringValue(createOut.NetworkInterface.PrivateIpAddress)}
+	}
+
+	log.Infof("Allocated subnet ENI %s (device %d) in subnet %s", eniID, deviceNumber, subnetID)
+	return meta, nil
+}
+
+// FreeBranchENI disassociates a branch ENI from the trunk and deletes it.
+func (cache *EC2InstanceMetadataCache) FreeBranchENI(eniID string) error {
+	cache.mu.Lock()
+	delete(cache.branchENIVlan, eniID)
+	associationID, ok := cache.branchENIAssoc[eniID]
+	delete(cache.branchENIAssoc, eniID)
+	cache.mu.Unlock()
+
+	if !ok {
+		log.Warnf("No trunk AssociationId recorded for branch ENI %s, skipping disassociate and deleting anyway", eniID)
+	} else if _, err := cache.ec2Client.DisassociateTrunkInterface(&ec2.DisassociateTrunkInterfaceInput{
+		AssociationId: aws.String(associationID),
+	}); err != nil {
+		log.Warnf("Failed to disassociate branch ENI %s (association %s) from trunk, attempting delete anyway: %v", eniID, associationID, err)
+	}
+
+	if _, err := cache.ec2Client.DeleteNetworkInterface(&ec2.DeleteNetworkInterfaceInput{
+		NetworkInterfaceId: aws.String(eniID),
+	}); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("awsutils: failed to delete branch ENI %s", eniID))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package k8sapi
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// SecurityGroupsAnnotation requests a branch ENI with the given
+	// comma-separated security group IDs instead of a secondary IP from the
+	// warm pool (see AssignPodBranchENI).
+	SecurityGroupsAnnotation = "vpc.amazonaws.com/pod-security-groups"
+
+	// RetainIPAnnotation requests that the pod keep the same IPv4 address
+	// across restarts, by namespace/name (see RetainPodIPv4Address).
+	RetainIPAnnotation = "vpc.amazonaws.com/retain-ip"
+
+	// PodSubnetAnnotation requests an IP from the named subnet's own ENI
+	// pool instead of the instance's default warm pool (see
+	// AssignPodIPv4AddressFromSubnet).
+	PodSubnetAnnotation = "vpc.amazonaws.com/pod-subnet"
+)
+
+// K8SAPIs is the subset of the k8s API ipamd needs: reading a pod's
+// annotations to decide how to satisfy its AddNetwork request.
+type K8SAPIs interface {
+	// GetPodAnnotations returns the annotations of the named pod. It
+	// returns an error if the pod can't be found or the API server can't be
+	// reached.
+	GetPodAnnotations(namespace, name string) (map[string]string, error)
+}
+
+// Client implements K8SAPIs against a live k8s API server.
+type Client struct {
+	clientset kubernetes.Interface
+}
+
+// NewClient creates a Client backed by the given clientset.
+func NewClient(clientset kubernetes.Interface) *Client {
+	return &Client{clientset: clientset}
+}
+
+// GetPodAnnotations returns the annotations of the named pod.
+func (c *Client) GetPodAnnotations(namespace, name string) (map[string]string, error) {
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pod.Annotations, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package k8sapi handles the k8s context related lookups and caching that
+// ipamd needs in order to assign IPs to pods.
+package k8sapi
+
+// K8SPodInfo provides the information that ipamd needs to identify a pod and
+// to key its IP allocation to that pod.
+type K8SPodInfo struct {
+	// Name is the pod's name.
+	Name string
+	// Namespace is the pod's namespace.
+	Namespace string
+	// Container is the sandbox (infra) container ID kubelet assigned to the pod.
+	Container string
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package k8sapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+const (
+	// podResourcesSocket is where kubelet exposes its PodResources gRPC API.
+	podResourcesSocket = "/var/lib/kubelet/pod-resources/kubelet.sock"
+
+	podResourcesDialTimeout = 5 * time.Second
+)
+
+// PodSandbox is one pod kubelet reports as currently running. ContainerID
+// holds the name of the pod's first container as kubelet's v1alpha1 API
+// reports it (empty if it reports none); it is not the sandbox (infra)
+// container ID ipamd keys its datastore bindings by.
+type PodSandbox struct {
+	Namespace   string
+	Name        string
+	ContainerID string
+}
+
+// PodResourcesClient queries kubelet's PodResources gRPC API for the
+// authoritative set of running pods. ipamd uses it to periodically reconcile
+// its datastore bindings, releasing those whose pod kubelet no longer
+// reports.
+type PodResourcesClient struct {
+	conn   *grpc.ClientConn
+	client podResourcesListerClient
+}
+
+// NewPodResourcesClient dials the kubelet PodResources gRPC socket.
+func NewPodResourcesClient() (*PodResourcesClient, error) {
+	return newPodResourcesClient(podResourcesSocket)
+}
+
+func newPodResourcesClient(socketPath string) (*PodResourcesClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), podResourcesDialTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, "unix://"+socketPath, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, errors.Wrap(err, "k8sapi: failed to dial kubelet PodResources socket")
+	}
+	return &PodResourcesClient{conn: conn, client: newGRPCPodResourcesListerClient(conn)}, nil
+}
+
+// ListPodSandboxes returns every pod kubelet currently has a sandbox for.
+func (c *PodResourcesClient) ListPodSandboxes(ctx context.Context) ([]PodSandbox, error) {
+	resp, err := c.client.List(ctx, &listPodResourcesRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "k8sapi: PodResources List failed")
+	}
+
+	sandboxes := make([]PodSandbox, 0, len(resp.PodResources))
+	for _, p := range resp.PodResources {
+		containerID := ""
+		if len(p.Containers) > 0 {
+			containerID = p.Containers[0].Id
+		}
+		sandboxes = append(sandboxes, PodSandbox{Namespace: p.Namespace, Name: p.Name, ContainerID: containerID})
+	}
+	return sandboxes, nil
+}
+
+// GetPodSandboxContainerID returns the ContainerID of the given pod's
+// PodSandbox (see PodSandbox), or an error if kubelet doesn't know about it.
+func (c *PodResourcesClient) GetPodSandboxContainerID(namespace, name string) (string, error) {
+	sandboxes, err := c.ListPodSandboxes(context.Background())
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sandboxes {
+		if s.Namespace == namespace && s.Name == name {
+			return s.ContainerID, nil
+		}
+	}
+	return "", errors.Errorf("k8sapi: kubelet has no sandbox for pod %s/%s", namespace, name)
+}
+
+// Close closes the underlying gRPC connection to kubelet.
+func (c *PodResourcesClient) Close() error {
+	return c.conn.Close()
+}
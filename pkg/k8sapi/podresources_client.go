@@ -0,0 +1,111 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package k8sapi
+
+import (
+	"context"
+
+	proto "github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// This file declares just enough of kubelet's v1alpha1 PodResources gRPC API
+// (https://github.com/kubernetes/kubelet/blob/master/pkg/apis/podresources)
+// for podResourcesListerClient to call it, rather than vendoring the full
+// generated client. The protobuf struct tags and field numbers below must
+// match the real v1alpha1 wire format exactly, or the default grpc-go proto
+// codec will silently decode every field as its zero value instead of
+// erroring.
+
+const podResourcesListMethod = "/v1alpha1.PodResourcesLister/List"
+
+type listPodResourcesRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *listPodResourcesRequest) Reset()         { *m = listPodResourcesRequest{} }
+func (m *listPodResourcesRequest) String() string { return proto.CompactTextString(m) }
+func (*listPodResourcesRequest) ProtoMessage()    {}
+
+// containerResources mirrors kubelet's ContainerResources message. Id holds
+// the container's "name" field as kubelet reports it; the v1alpha1 API has no
+// separate container ID.
+type containerResources struct {
+	Id string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *containerResources) Reset()         { *m = containerResources{} }
+func (m *containerResources) String() string { return proto.CompactTextString(m) }
+func (*containerResources) ProtoMessage()    {}
+
+type podResources struct {
+	Name       string                `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Namespace  string                `protobuf:"bytes,2,opt,name=namespace" json:"namespace,omitempty"`
+	Containers []*containerResources `protobuf:"bytes,3,rep,name=containers" json:"containers,omitempty"`
+
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *podResources) Reset()         { *m = podResources{} }
+func (m *podResources) String() string { return proto.CompactTextString(m) }
+func (*podResources) ProtoMessage()    {}
+
+type listPodResourcesResponse struct {
+	PodResources []*podResources `protobuf:"bytes,1,rep,name=pod_resources,json=podResources" json:"pod_resources,omitempty"`
+
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *listPodResourcesResponse) Reset()         { *m = listPodResourcesResponse{} }
+func (m *listPodResourcesResponse) String() string { return proto.CompactTextString(m) }
+func (*listPodResourcesResponse) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*listPodResourcesRequest)(nil), "v1alpha1.ListPodResourcesRequest")
+	proto.RegisterType((*containerResources)(nil), "v1alpha1.ContainerResources")
+	proto.RegisterType((*podResources)(nil), "v1alpha1.PodResources")
+	proto.RegisterType((*listPodResourcesResponse)(nil), "v1alpha1.ListPodResourcesResponse")
+}
+
+// podResourcesListerClient is the narrow slice of kubelet's PodResources API
+// ipamd needs, split out so it can be faked in tests without a real socket.
+type podResourcesListerClient interface {
+	List(ctx context.Context, in *listPodResourcesRequest) (*listPodResourcesResponse, error)
+}
+
+type grpcPodResourcesListerClient struct {
+	conn *grpc.ClientConn
+}
+
+func newGRPCPodResourcesListerClient(conn *grpc.ClientConn) podResourcesListerClient {
+	return &grpcPodResourcesListerClient{conn: conn}
+}
+
+func (c *grpcPodResourcesListerClient) List(ctx context.Context, in *listPodResourcesRequest) (*listPodResourcesResponse, error) {
+	out := new(listPodResourcesResponse)
+	if err := c.conn.Invoke(ctx, podResourcesListMethod, in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
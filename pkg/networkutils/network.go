@@ -0,0 +1,66 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package networkutils configures the host's route rules and iptables SNAT
+// exclusions used by the CNI plugin's data path.
+package networkutils
+
+import (
+	"os"
+)
+
+const (
+	envExternalSNAT     = "AWS_VPC_K8S_CNI_EXTERNALSNAT"
+	envExcludeSNATCIDRs = "AWS_VPC_K8S_CNI_EXCLUDE_SNAT_CIDRS"
+)
+
+// NetworkAPIs is the interface ipamd uses to query the host's SNAT
+// configuration when building an AddNetworkReply.
+type NetworkAPIs interface {
+	UseExternalSNAT() bool
+	GetExcludeSNATCIDRs() []string
+}
+
+// Network implements NetworkAPIs against the host's iptables/route rule setup.
+type Network struct{}
+
+// New creates a Network.
+func New() *Network {
+	return &Network{}
+}
+
+// UseExternalSNAT returns true if the operator has opted the node out of
+// ipamd-managed SNAT, e.g. because an external NAT gateway handles it.
+func (n *Network) UseExternalSNAT() bool {
+	return os.Getenv(envExternalSNAT) == "true"
+}
+
+// GetExcludeSNATCIDRs returns the CIDRs that should be excluded from the SNAT
+// chain in addition to the VPC's own CIDRs.
+func (n *Network) GetExcludeSNATCIDRs() []string {
+	raw := os.Getenv(envExcludeSNATCIDRs)
+	if raw == "" {
+		return nil
+	}
+	var cidrs []string
+	start := 0
+	for i := 0; i <= len(raw); i++ {
+		if i == len(raw) || raw[i] == ',' {
+			if i > start {
+				cidrs = append(cidrs, raw[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return cidrs
+}
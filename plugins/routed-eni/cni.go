@@ -0,0 +1,102 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package main implements the routed-eni CNI plugin binary, which talks to
+// ipamd over gRPC to get and release IP addresses for pods.
+package main
+
+import (
+	"context"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
+
+	"github.com/aws/amazon-vpc-cni-k8s/plugins/routed-eni/driver"
+)
+
+const ipamdAddress = "127.0.0.1:50051"
+
+func cmdAdd(args *skel.CmdArgs, k8sPodName, k8sPodNamespace, k8sPodInfraContainerID string) error {
+	conn, err := grpc.Dial(ipamdAddress, grpc.WithInsecure())
+	if err != nil {
+		return errors.Wrap(err, "cni: failed to connect to ipamd")
+	}
+	defer conn.Close()
+	client := pb.NewCNIBackendClient(conn)
+
+	reply, err := client.AddNetwork(context.Background(), &pb.AddNetworkRequest{
+		K8S_POD_NAME:               k8sPodName,
+		K8S_POD_NAMESPACE:          k8sPodNamespace,
+		K8S_POD_INFRA_CONTAINER_ID: k8sPodInfraContainerID,
+		Netns:                      args.Netns,
+		IfName:                     args.IfName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "cni: AddNetwork failed")
+	}
+	if !reply.Success {
+		return errors.New("cni: ipamd failed to assign an IP address")
+	}
+
+	if reply.BranchENIMAC != "" {
+		// ipamd satisfied this pod with a branch ENI for per-pod security
+		// groups; set up the VLAN sub-interface it told us about instead of
+		// the usual secondary-IP route rules.
+		if err := driver.SetupBranchENI(reply.BranchENIMAC, int(reply.VlanID), args.Netns, args.IfName); err != nil {
+			return errors.Wrap(err, "cni: failed to set up branch ENI VLAN sub-interface")
+		}
+	}
+
+	return nil
+}
+
+func cmdDel(args *skel.CmdArgs, k8sPodName, k8sPodNamespace, k8sPodInfraContainerID, ipv4Addr string) error {
+	conn, err := grpc.Dial(ipamdAddress, grpc.WithInsecure())
+	if err != nil {
+		return errors.Wrap(err, "cni: failed to connect to ipamd")
+	}
+	defer conn.Close()
+	client := pb.NewCNIBackendClient(conn)
+
+	reply, err := client.DelNetwork(context.Background(), &pb.DelNetworkRequest{
+		K8S_POD_NAME:               k8sPodName,
+		K8S_POD_NAMESPACE:          k8sPodNamespace,
+		K8S_POD_INFRA_CONTAINER_ID: k8sPodInfraContainerID,
+		IfName:                     args.IfName,
+		IPv4Addr:                   ipv4Addr,
+	})
+	if err != nil {
+		return errors.Wrap(err, "cni: DelNetwork failed")
+	}
+
+	if reply.ReleasePending {
+		// DelNetwork only parked the IP pending release; tell ipamd to free
+		// it. This function tears down no route rules, iptables SNAT
+		// exclusions or veth first, so the IP is freed right after
+		// DelNetwork returns.
+		if _, err := client.ReleaseIPResources(context.Background(), &pb.ReleaseIPResourcesRequest{
+			K8S_POD_NAME:      k8sPodName,
+			K8S_POD_NAMESPACE: k8sPodNamespace,
+			IPv4Addr:          reply.IPv4Addr,
+		}); err != nil {
+			return errors.Wrap(err, "cni: ReleaseIPResources failed")
+		}
+	}
+
+	return nil
+}
+
+func main() {}
@@ -0,0 +1,85 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package driver sets up the host and container-side networking for a pod's
+// ENI once ipamd has assigned it.
+package driver
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// SetupBranchENI creates a VLAN sub-interface on top of the branch ENI's
+// host-side link (identified by its MAC address) and moves it into the
+// pod's network namespace so the pod sees a plain interface while the host
+// keeps routing on the VLAN tag the trunk ENI was associated on.
+func SetupBranchENI(branchENIMAC string, vlanID int, netnsPath string, ifName string) error {
+	parent, err := linkByMAC(branchENIMAC)
+	if err != nil {
+		return errors.Wrap(err, "driver: failed to find branch ENI host interface")
+	}
+
+	vlanLink := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        fmt.Sprintf("vlan.eth.%d", vlanID),
+			ParentIndex: parent.Attrs().Index,
+		},
+		VlanId: vlanID,
+	}
+	if err := netlink.LinkAdd(vlanLink); err != nil {
+		return errors.Wrap(err, "driver: failed to create VLAN sub-interface for branch ENI")
+	}
+
+	ns, err := netlinkNamespace(netnsPath)
+	if err != nil {
+		return errors.Wrap(err, "driver: failed to open pod network namespace")
+	}
+	if err := netlink.LinkSetNsFd(vlanLink, int(ns)); err != nil {
+		return errors.Wrap(err, "driver: failed to move VLAN sub-interface into pod namespace")
+	}
+
+	return nil
+}
+
+// TeardownBranchENI removes the VLAN sub-interface created by SetupBranchENI.
+// It is a best-effort cleanup: the branch ENI itself is deleted by ipamd.
+func TeardownBranchENI(vlanID int) error {
+	name := fmt.Sprintf("vlan.eth.%d", vlanID)
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		// Already gone, e.g. because the netns was torn down first.
+		return nil
+	}
+	return netlink.LinkDel(link)
+}
+
+func netlinkNamespace(netnsPath string) (netns.NsHandle, error) {
+	return netns.GetFromPath(netnsPath)
+}
+
+func linkByMAC(mac string) (netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		if l.Attrs().HardwareAddr.String() == mac {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("driver: no host interface with MAC %s", mac)
+}